@@ -0,0 +1,89 @@
+package localstore
+
+import (
+	"io"
+	"io/fs"
+	"path/filepath"
+)
+
+// CacheOnRead wraps a slow Base FS with a fast Cache FS, copying each file
+// into the cache the first time it is opened. Writes and removals go
+// straight to Base; the cached copy is dropped on Remove so a later read
+// doesn't serve stale data.
+type CacheOnRead struct {
+	Base  FS
+	Cache FS
+}
+
+// NewCacheOnRead returns a CacheOnRead layering cache in front of base.
+func NewCacheOnRead(base FS, cache FS) *CacheOnRead {
+	return &CacheOnRead{Base: base, Cache: cache}
+}
+
+func (c *CacheOnRead) Open(name string) (File, error) {
+	if f, err := c.Cache.Open(name); err == nil {
+		return f, nil
+	}
+
+	f, err := c.Base.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return c.Base.Open(name)
+	}
+
+	if err := c.Cache.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return c.Base.Open(name)
+	}
+
+	w, err := c.Cache.Create(name)
+	if err != nil {
+		return c.Base.Open(name)
+	}
+	if _, err := io.Copy(w, f); err != nil {
+		_ = w.Close()
+		_ = c.Cache.Remove(name)
+		return c.Base.Open(name)
+	}
+	if err := w.Close(); err != nil {
+		_ = c.Cache.Remove(name)
+		return c.Base.Open(name)
+	}
+
+	return c.Cache.Open(name)
+}
+
+func (c *CacheOnRead) Create(name string) (File, error) {
+	_ = c.Cache.Remove(name)
+	return c.Base.Create(name)
+}
+
+func (c *CacheOnRead) Remove(name string) error {
+	_ = c.Cache.Remove(name)
+	return c.Base.Remove(name)
+}
+
+func (c *CacheOnRead) Rename(oldname string, newname string) error {
+	_ = c.Cache.Remove(oldname)
+	_ = c.Cache.Remove(newname)
+	return c.Base.Rename(oldname, newname)
+}
+
+func (c *CacheOnRead) ReadDir(name string) ([]string, error) {
+	return c.Base.ReadDir(name)
+}
+
+func (c *CacheOnRead) Stat(name string) (fs.FileInfo, error) {
+	return c.Base.Stat(name)
+}
+
+func (c *CacheOnRead) MkdirAll(name string, perm fs.FileMode) error {
+	return c.Base.MkdirAll(name, perm)
+}
@@ -0,0 +1,75 @@
+package localstore
+
+import (
+	"io"
+	"io/fs"
+	"os"
+)
+
+// File is the subset of *os.File that an FS hands back from Open and
+// Create.
+type File interface {
+	io.Reader
+	io.Writer
+	io.Closer
+	Stat() (fs.FileInfo, error)
+
+	// Sync commits the file's contents to stable storage, as used by
+	// Tx to make sure staged writes survive a crash before the manifest
+	// recording them is written.
+	Sync() error
+}
+
+// FS abstracts the filesystem that LocalStore reads and writes through.
+// The default, used when Options[T].FS is nil, is the local OS
+// filesystem, but callers can supply their own implementation: MemFS for
+// tests, a read-only base with a writable overlay, a CacheOnRead tier in
+// front of a slow remote FS, and so on.
+type FS interface {
+	Open(name string) (File, error)
+	Create(name string) (File, error)
+	Remove(name string) error
+	Rename(oldname string, newname string) error
+	ReadDir(name string) ([]string, error)
+	Stat(name string) (fs.FileInfo, error)
+	MkdirAll(name string, perm fs.FileMode) error
+}
+
+// osFS implements FS directly on top of the local OS filesystem.
+type osFS struct{}
+
+func (osFS) Open(name string) (File, error) {
+	return os.Open(name)
+}
+
+func (osFS) Create(name string) (File, error) {
+	return os.Create(name)
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFS) Rename(oldname string, newname string) error {
+	return os.Rename(oldname, newname)
+}
+
+func (osFS) ReadDir(name string) ([]string, error) {
+	entries, err := os.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names, nil
+}
+
+func (osFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFS) MkdirAll(name string, perm fs.FileMode) error {
+	return os.MkdirAll(name, perm)
+}
@@ -0,0 +1,279 @@
+package localstore
+
+import (
+	"bytes"
+	"encoding/gob"
+	"net/url"
+	"path/filepath"
+	"sort"
+)
+
+const indexManifestDir = ".manifest"
+
+// indexDir returns the directory holding every entry for indexName.
+func (s *LocalStore[T]) indexDir(indexName string) string {
+	return filepath.Join(s.dir, ".idx", indexName)
+}
+
+// indexKeyDir returns the directory holding every primary key currently
+// filed under indexKey within indexName.
+func (s *LocalStore[T]) indexKeyDir(indexName string, indexKey string) string {
+	return filepath.Join(s.indexDir(indexName), url.PathEscape(indexKey))
+}
+
+// indexManifestPath records, for a primary entry name, the index keys it
+// is currently filed under within indexName, so a later Put or Delete
+// can remove the stale entries without scanning every indexKey
+// directory.
+func (s *LocalStore[T]) indexManifestPath(indexName string, name string) string {
+	return filepath.Join(s.indexDir(indexName), indexManifestDir, name)
+}
+
+func (s *LocalStore[T]) readIndexManifest(indexName string, name string) []string {
+	f, err := s.fs.Open(s.indexManifestPath(indexName, name))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var keys []string
+	if err := gob.NewDecoder(f).Decode(&keys); err != nil {
+		return nil
+	}
+	return keys
+}
+
+func (s *LocalStore[T]) writeIndexManifest(indexName string, name string, keys []string) error {
+	path := s.indexManifestPath(indexName, name)
+	if err := s.fs.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(keys); err != nil {
+		return err
+	}
+
+	f, err := s.fs.Create(path)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(buf.Bytes())
+	if err1 := f.Close(); err1 != nil && err == nil {
+		err = err1
+	}
+	return err
+}
+
+// putIndexes files name under every index key its value produces for
+// each configured index, removes it from any index key it no longer
+// belongs to, and records the new set of keys in the manifest.
+// s.mutex must be held.
+func (s *LocalStore[T]) putIndexes(name string, value T) error {
+	for indexName, extract := range s.indexes {
+		newKeys := extract(value)
+		oldKeys := s.readIndexManifest(indexName, name)
+
+		for _, old := range oldKeys {
+			if containsString(newKeys, old) {
+				continue
+			}
+			_ = s.fs.Remove(filepath.Join(s.indexKeyDir(indexName, old), name))
+		}
+
+		for _, key := range newKeys {
+			if containsString(oldKeys, key) {
+				continue
+			}
+			dir := s.indexKeyDir(indexName, key)
+			if err := s.fs.MkdirAll(dir, 0755); err != nil {
+				return err
+			}
+			f, err := s.fs.Create(filepath.Join(dir, name))
+			if err != nil {
+				return err
+			}
+			if err := f.Close(); err != nil {
+				return err
+			}
+		}
+
+		if len(newKeys) == 0 {
+			_ = s.fs.Remove(s.indexManifestPath(indexName, name))
+			continue
+		}
+		if err := s.writeIndexManifest(indexName, name, newKeys); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteIndexes removes name from every index key it was filed under.
+// s.mutex must be held.
+func (s *LocalStore[T]) deleteIndexes(name string) {
+	for indexName := range s.indexes {
+		for _, key := range s.readIndexManifest(indexName, name) {
+			_ = s.fs.Remove(filepath.Join(s.indexKeyDir(indexName, key), name))
+		}
+		_ = s.fs.Remove(s.indexManifestPath(indexName, name))
+	}
+}
+
+// rebuildIndex rebuilds indexName from scratch by scanning every entry
+// already in the primary store. s.mutex must be held.
+func (s *LocalStore[T]) rebuildIndex(indexName string) error {
+	extract := s.indexes[indexName]
+
+	for _, name := range s.index {
+		data, ok := s.readRawLocked(name)
+		if !ok {
+			continue
+		}
+		value, err := s.decoder.Decode(bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+
+		keys := extract(value)
+		for _, key := range keys {
+			dir := s.indexKeyDir(indexName, key)
+			if err := s.fs.MkdirAll(dir, 0755); err != nil {
+				return err
+			}
+			f, err := s.fs.Create(filepath.Join(dir, name))
+			if err != nil {
+				return err
+			}
+			if err := f.Close(); err != nil {
+				return err
+			}
+		}
+		if len(keys) > 0 {
+			if err := s.writeIndexManifest(indexName, name, keys); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// FindBy returns every value currently filed under indexKey in the
+// index named indexName.
+func (s *LocalStore[T]) FindBy(indexName string, indexKey string) ([]T, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	names, err := s.fs.ReadDir(s.indexKeyDir(indexName, indexKey))
+	if err != nil {
+		return nil, nil
+	}
+	sort.Strings(names)
+
+	values := make([]T, 0, len(names))
+	for _, name := range names {
+		data, ok := s.readRawLocked(name)
+		if !ok {
+			continue
+		}
+		value, err := s.decoder.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+// RangeBy returns the values filed under index keys between lo and hi
+// (inclusive), ordered by index key using the store's Comparator and
+// then windowed by offset/limit the same way List is.
+func (s *LocalStore[T]) RangeBy(indexName string, lo string, hi string, offset int, limit int) (List[T], error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	indexKeys, err := s.fs.ReadDir(s.indexDir(indexName))
+	if err != nil {
+		return List[T]{}, nil
+	}
+
+	// Index key directories are percent-escaped on disk (indexKeyDir), and
+	// percent-escaping is not order-preserving, so lo/hi must be compared
+	// against the unescaped index key, not the escaped directory name.
+	type rangeKey struct {
+		key string
+		dir string
+	}
+
+	matching := make([]rangeKey, 0, len(indexKeys))
+	for _, indexKey := range indexKeys {
+		if indexKey == indexManifestDir {
+			continue
+		}
+		key, err := url.PathUnescape(indexKey)
+		if err != nil {
+			continue
+		}
+		if s.comparator.Compare(key, lo) < 0 || s.comparator.Compare(key, hi) > 0 {
+			continue
+		}
+		matching = append(matching, rangeKey{key: key, dir: indexKey})
+	}
+	sort.Slice(matching, func(i, j int) bool {
+		return s.comparator.Compare(matching[i].key, matching[j].key) < 0
+	})
+
+	names := make([]string, 0, len(matching))
+	for _, m := range matching {
+		entries, err := s.fs.ReadDir(filepath.Join(s.indexDir(indexName), m.dir))
+		if err != nil {
+			continue
+		}
+		sort.Strings(entries)
+		names = append(names, entries...)
+	}
+
+	// limit is a count, not an absolute end index.
+	end := len(names)
+	if limit >= 0 {
+		end = offset + limit
+		if end > len(names) {
+			end = len(names)
+		}
+	}
+	if offset > end {
+		offset = end
+	}
+
+	values := make([]T, 0, end-offset)
+	for i := offset; i < end; i++ {
+		data, ok := s.readRawLocked(names[i])
+		if !ok {
+			continue
+		}
+		value, err := s.decoder.Decode(bytes.NewReader(data))
+		if err != nil {
+			return List[T]{}, err
+		}
+		values = append(values, value)
+	}
+
+	return List[T]{
+		Values: values,
+		Offset: offset,
+		Limit:  limit,
+		Total:  len(names),
+	}, nil
+}
@@ -0,0 +1,162 @@
+package localstore
+
+import (
+	"bytes"
+	"testing"
+)
+
+type person struct {
+	Name string
+	City string
+}
+
+func newPeopleStore(fs FS) *LocalStore[person] {
+	return New[person]("/store", &Options[person]{
+		FS: fs,
+		Indexes: map[string]func(person) []string{
+			"city": func(p person) []string { return []string{p.City} },
+		},
+	})
+}
+
+func TestFindBy(t *testing.T) {
+	s := newPeopleStore(NewMemFS())
+	if err := s.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if err := s.Put("alice", person{Name: "alice", City: "nyc"}); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if err := s.Put("bob", person{Name: "bob", City: "nyc"}); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if err := s.Put("carol", person{Name: "carol", City: "sf"}); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	got, err := s.FindBy("city", "nyc")
+	if err != nil {
+		t.Fatalf("FindBy() error = %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("FindBy(city, nyc) returned %d values, want 2", len(got))
+	}
+
+	// Moving alice to sf should drop her from the nyc index.
+	if err := s.Put("alice", person{Name: "alice", City: "sf"}); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	got, err = s.FindBy("city", "nyc")
+	if err != nil {
+		t.Fatalf("FindBy() error = %v", err)
+	}
+	if len(got) != 1 || got[0].Name != "bob" {
+		t.Fatalf("FindBy(city, nyc) after move = %+v, want only bob", got)
+	}
+
+	if err := s.Delete("bob"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	got, err = s.FindBy("city", "nyc")
+	if err != nil {
+		t.Fatalf("FindBy() error = %v", err)
+	}
+	if len(got) != 0 {
+		t.Fatalf("FindBy(city, nyc) after delete = %+v, want none", got)
+	}
+}
+
+func TestRangeBy(t *testing.T) {
+	s := newPeopleStore(NewMemFS())
+	if err := s.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	for _, p := range []person{
+		{Name: "a", City: "austin"},
+		{Name: "b", City: "boston"},
+		{Name: "c", City: "chicago"},
+		{Name: "d", City: "denver"},
+	} {
+		if err := s.Put(p.Name, p); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+	}
+
+	list, err := s.RangeBy("city", "boston", "chicago", 0, -1)
+	if err != nil {
+		t.Fatalf("RangeBy() error = %v", err)
+	}
+	if len(list.Values) != 2 {
+		t.Fatalf("RangeBy(boston, chicago) returned %d values, want 2", len(list.Values))
+	}
+}
+
+func TestFindByAfterReplicatedPut(t *testing.T) {
+	s := newPeopleStore(NewMemFS())
+	if err := s.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	// putRaw/deleteRaw are what a Replicator uses to apply values pulled
+	// from a peer; they must maintain secondary indexes the same as Put
+	// and Delete do.
+	name := nameFromKey("dave", s.ext)
+
+	var buf bytes.Buffer
+	if err := s.encoder.Encode(&buf, person{Name: "dave", City: "nyc"}); err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	if err := s.putRaw(name, buf.Bytes()); err != nil {
+		t.Fatalf("putRaw() error = %v", err)
+	}
+
+	got, err := s.FindBy("city", "nyc")
+	if err != nil {
+		t.Fatalf("FindBy() error = %v", err)
+	}
+	if len(got) != 1 || got[0].Name != "dave" {
+		t.Fatalf("FindBy(city, nyc) = %+v, want dave", got)
+	}
+
+	if err := s.deleteRaw(name); err != nil {
+		t.Fatalf("deleteRaw() error = %v", err)
+	}
+	got, err = s.FindBy("city", "nyc")
+	if err != nil {
+		t.Fatalf("FindBy() error = %v", err)
+	}
+	if len(got) != 0 {
+		t.Fatalf("FindBy(city, nyc) after deleteRaw = %+v, want none", got)
+	}
+}
+
+func TestIndexRebuildOnLoad(t *testing.T) {
+	fs := NewMemFS()
+
+	// Write entries through a store with no Indexes configured, so the
+	// .idx directory never gets created.
+	plain := New[person]("/store", &Options[person]{FS: fs})
+	if err := plain.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if err := plain.Put("alice", person{Name: "alice", City: "nyc"}); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	// Reopening over the same FS with the city index configured should
+	// rebuild it from the existing entries on Load.
+	indexed := newPeopleStore(fs)
+	if err := indexed.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	got, err := indexed.FindBy("city", "nyc")
+	if err != nil {
+		t.Fatalf("FindBy() error = %v", err)
+	}
+	if len(got) != 1 || got[0].Name != "alice" {
+		t.Fatalf("FindBy(city, nyc) = %+v, want alice", got)
+	}
+}
@@ -0,0 +1,97 @@
+package localstore
+
+import (
+	"fmt"
+	"testing"
+)
+
+func seedStore(t *testing.T, s *LocalStore[record], n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("key-%02d", i)
+		if err := s.Put(key, record{Value: key}); err != nil {
+			t.Fatalf("Put(%q) error = %v", key, err)
+		}
+	}
+}
+
+func TestListConcurrentMatchesSequential(t *testing.T) {
+	sequential := newStringStore(NewMemFS())
+	if err := sequential.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	seedStore(t, sequential, 20)
+
+	concurrent := New[record]("/store", &Options[record]{FS: sequential.fs, ListConcurrency: 4})
+	if err := concurrent.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	want, err := sequential.List(0, -1)
+	if err != nil {
+		t.Fatalf("sequential.List() error = %v", err)
+	}
+	got, err := concurrent.List(0, -1)
+	if err != nil {
+		t.Fatalf("concurrent.List() error = %v", err)
+	}
+
+	if len(got.Values) != len(want.Values) {
+		t.Fatalf("got %d values, want %d", len(got.Values), len(want.Values))
+	}
+	for i := range want.Values {
+		if got.Values[i].Value != want.Values[i].Value {
+			t.Fatalf("Values[%d] = %+v, want %+v", i, got.Values[i], want.Values[i])
+		}
+	}
+}
+
+func TestListOffsetAndLimitWindow(t *testing.T) {
+	s := newStringStore(NewMemFS())
+	if err := s.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	seedStore(t, s, 10)
+
+	list, err := s.List(2, 3)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(list.Values) != 3 {
+		t.Fatalf("List(2, 3) returned %d values, want 3", len(list.Values))
+	}
+	for i, want := range []string{"key-02", "key-03", "key-04"} {
+		if list.Values[i].Value != want {
+			t.Fatalf("List(2, 3).Values[%d] = %+v, want %q", i, list.Values[i], want)
+		}
+	}
+
+	list, err = s.List(8, 5)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(list.Values) != 2 {
+		t.Fatalf("List(8, 5) returned %d values, want 2", len(list.Values))
+	}
+}
+
+func TestListIncludeKey(t *testing.T) {
+	s := New[record]("/store", &Options[record]{
+		FS: NewMemFS(),
+		IncludeKey: func(key string) bool {
+			return key == "key-05"
+		},
+	})
+	if err := s.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	seedStore(t, s, 10)
+
+	list, err := s.List(0, -1)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(list.Values) != 1 || list.Values[0].Value != "key-05" {
+		t.Fatalf("List() = %+v, want only key-05", list.Values)
+	}
+}
@@ -1,21 +1,26 @@
 package localstore
 
 import (
+	"bytes"
 	"encoding/gob"
+	"errors"
 	"io"
 	"net/url"
 	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
-
-	"github.com/thamaji/files"
+	"time"
 )
 
+// ErrNotExist is returned by Get when key has no entry in the store.
+var ErrNotExist = errors.New("localstore: not exist")
+
 func New[T any](dir string, opt *Options[T]) *LocalStore[T] {
 	s := &LocalStore[T]{
 		dir:        dir,
 		ext:        ".dat",
+		fs:         osFS{},
 		comparator: ComparatorFunc(strings.Compare),
 		encoder: EncoderFunc[T](func(w io.Writer, v T) error {
 			return gob.NewEncoder(w).Encode(v)
@@ -30,6 +35,9 @@ func New[T any](dir string, opt *Options[T]) *LocalStore[T] {
 		if opt.Ext != "" {
 			s.ext = opt.Ext
 		}
+		if opt.FS != nil {
+			s.fs = opt.FS
+		}
 		if opt.Comparator != nil {
 			s.comparator = opt.Comparator
 		}
@@ -39,6 +47,21 @@ func New[T any](dir string, opt *Options[T]) *LocalStore[T] {
 		if opt.Decoder != nil {
 			s.decoder = opt.Decoder
 		}
+		if opt.Conflict != nil {
+			s.conflict = opt.Conflict
+		}
+		if opt.ListConcurrency > 0 {
+			s.listConcurrency = opt.ListConcurrency
+		}
+		if opt.ListThrottle > 0 {
+			s.listThrottle = opt.ListThrottle
+		}
+		if opt.IncludeKey != nil {
+			s.includeKey = opt.IncludeKey
+		}
+		if opt.Indexes != nil {
+			s.indexes = opt.Indexes
+		}
 	}
 	return s
 }
@@ -47,10 +70,28 @@ type LocalStore[T any] struct {
 	mutex      sync.RWMutex
 	dir        string
 	ext        string
+	fs         FS
 	comparator Comparator
 	encoder    Encoder[T]
 	decoder    Decoder[T]
 	index      []string
+
+	// conflict is carried over from Options[T] so a Replicator created
+	// later with NewReplicator picks up the same policy.
+	conflict func(local T, remote T) T
+
+	// hook, when set by a Replicator, is called with the encoded bytes
+	// of every entry a Put or Delete changes, so the replicator can
+	// track versions and stream updates without re-scanning the store.
+	hook func(name string, data []byte, deleted bool)
+
+	listConcurrency int
+	listThrottle    float64
+	includeKey      func(key string) bool
+
+	// indexes maps an index name to the function extracting its keys
+	// from a value; see FindBy and RangeBy.
+	indexes map[string]func(T) []string
 }
 
 type List[T any] struct {
@@ -64,7 +105,23 @@ func (s *LocalStore[T]) Load() error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	names := files.MustReadDirnames(s.dir)
+	if err := s.fs.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+
+	if txNames, err := s.fs.ReadDir(s.txDir()); err == nil {
+		for _, id := range txNames {
+			if err := s.recoverTx(id); err != nil {
+				return err
+			}
+		}
+	}
+
+	names, err := s.fs.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+
 	index := make([]string, 0, len(names))
 	for _, name := range names {
 		if filepath.Ext(name) != s.ext {
@@ -77,6 +134,15 @@ func (s *LocalStore[T]) Load() error {
 	})
 	s.index = index
 
+	for indexName := range s.indexes {
+		if _, err := s.fs.Stat(s.indexDir(indexName)); err == nil {
+			continue
+		}
+		if err := s.rebuildIndex(indexName); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -84,36 +150,53 @@ func (s *LocalStore[T]) List(offset int, limit int) (List[T], error) {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 
-	cap := limit
-	if cap < 0 {
-		cap = len(s.index)
-	}
-
-	values := make([]T, 0, cap)
-
-	for i := offset; i < cap; i++ {
-		f, err := files.Open(filepath.Join(s.dir, s.index[i]))
-		if err != nil {
-			return List[T]{}, err
+	// limit is a count, not an absolute end index.
+	end := len(s.index)
+	if limit >= 0 {
+		end = offset + limit
+		if end > len(s.index) {
+			end = len(s.index)
 		}
+	}
+	if offset > end {
+		offset = end
+	}
 
-		fi, err := f.Stat()
-		if err != nil {
-			_ = f.Close()
-			return List[T]{}, err
-		}
-		if fi.IsDir() {
-			_ = f.Close()
+	hint := end - offset
+	if hint < 0 {
+		hint = 0
+	}
+	indices := make([]int, 0, hint)
+	for i := offset; i < end; i++ {
+		if s.includeKey != nil && !s.includeKey(keyFromName(s.index[i], s.ext)) {
 			continue
 		}
+		indices = append(indices, i)
+	}
+
+	slots := make([]listSlot[T], len(indices))
 
-		value, err := s.decoder.Decode(f)
-		_ = f.Close()
-		if err != nil {
-			return List[T]{}, err
+	var loadErr error
+	if s.listConcurrency <= 1 {
+		for slot, i := range indices {
+			slots[slot] = s.loadListEntry(i)
+			if slots[slot].err != nil {
+				loadErr = slots[slot].err
+				break
+			}
 		}
+	} else {
+		loadErr = s.listConcurrently(indices, slots)
+	}
+	if loadErr != nil {
+		return List[T]{}, loadErr
+	}
 
-		values = append(values, value)
+	values := make([]T, 0, len(slots))
+	for _, slot := range slots {
+		if slot.ok {
+			values = append(values, slot.value)
+		}
 	}
 
 	list := List[T]{
@@ -126,6 +209,84 @@ func (s *LocalStore[T]) List(offset int, limit int) (List[T], error) {
 	return list, nil
 }
 
+// listSlot holds the outcome of decoding one entry during List, indexed
+// by its position in the result so concurrent workers can write their
+// results in any order while the output stays sorted.
+type listSlot[T any] struct {
+	value T
+	ok    bool
+	err   error
+}
+
+// listThrottleUnit is the base sleep a worker pauses for between files
+// at Options[T].ListThrottle == 1; lower throttle values scale it down.
+const listThrottleUnit = 10 * time.Millisecond
+
+func (s *LocalStore[T]) loadListEntry(i int) listSlot[T] {
+	f, err := s.fs.Open(filepath.Join(s.dir, s.index[i]))
+	if err != nil {
+		return listSlot[T]{err: err}
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return listSlot[T]{err: err}
+	}
+	if fi.IsDir() {
+		return listSlot[T]{}
+	}
+
+	value, err := s.decoder.Decode(f)
+	if err != nil {
+		return listSlot[T]{err: err}
+	}
+
+	return listSlot[T]{value: value, ok: true}
+}
+
+// listConcurrently fans index entries out across s.listConcurrency
+// worker goroutines, writing each result into slots at its position so
+// the caller sees the same sorted order as the sequential path.
+func (s *LocalStore[T]) listConcurrently(indices []int, slots []listSlot[T]) error {
+	type job struct {
+		slot int
+		i    int
+	}
+	jobs := make(chan job)
+
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var firstErr error
+
+	for w := 0; w < s.listConcurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				if s.listThrottle > 0 {
+					time.Sleep(time.Duration(s.listThrottle * float64(listThrottleUnit)))
+				}
+
+				slot := s.loadListEntry(j.i)
+				if slot.err != nil {
+					errOnce.Do(func() { firstErr = slot.err })
+					continue
+				}
+				slots[j.slot] = slot
+			}
+		}()
+	}
+
+	for slot, i := range indices {
+		jobs <- job{slot: slot, i: i}
+	}
+	close(jobs)
+	wg.Wait()
+
+	return firstErr
+}
+
 func (s *LocalStore[T]) Get(key string) (T, error) {
 	name := url.PathEscape(key) + s.ext
 	path := filepath.Join(s.dir, name)
@@ -140,7 +301,7 @@ func (s *LocalStore[T]) Get(key string) (T, error) {
 		return *new(T), ErrNotExist
 	}
 
-	f, err := files.OpenFileReader(path)
+	f, err := s.fs.Open(path)
 	if err != nil {
 		return *new(T), err
 	}
@@ -153,18 +314,41 @@ func (s *LocalStore[T]) Get(key string) (T, error) {
 	return value, nil
 }
 
+// Put encodes value and writes it under key, going through the same
+// staged-commit path as a Tx so a crash mid-write can never leave a
+// half-written entry. It never returns ErrConflict.
 func (s *LocalStore[T]) Put(key string, value T) error {
-	name := url.PathEscape(key) + s.ext
+	tx := s.Begin()
+	if err := tx.Put(key, value); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Delete removes key, going through the same staged-commit path as a Tx.
+// It never returns ErrConflict.
+func (s *LocalStore[T]) Delete(key string) error {
+	tx := s.Begin()
+	if err := tx.Delete(key); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// putLocked writes the already-encoded data for name and inserts it into
+// the index. s.mutex must be held.
+func (s *LocalStore[T]) putLocked(name string, data []byte) error {
 	path := filepath.Join(s.dir, name)
 
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+	if err := s.fs.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
 
-	f, err := files.OpenFileWriter(path)
+	f, err := s.fs.Create(path)
 	if err != nil {
 		return err
 	}
-	err = s.encoder.Encode(f, value)
+	_, err = f.Write(data)
 	if err1 := f.Close(); err1 != nil && err == nil {
 		err = err1
 	}
@@ -172,23 +356,76 @@ func (s *LocalStore[T]) Put(key string, value T) error {
 		return err
 	}
 
-	i, ok := sort.Find(len(s.index), func(i int) int {
+	s.insertIntoIndexLocked(name)
+
+	return nil
+}
+
+// readRaw returns the encoded bytes stored under name (an escaped key
+// plus extension), as used by a Replicator to build an index or serve a
+// peer's pull request.
+func (s *LocalStore[T]) readRaw(name string) ([]byte, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.readRawLocked(name)
+}
+
+// readRawLocked returns the encoded bytes stored under name. s.mutex
+// must already be held (for reading or writing).
+func (s *LocalStore[T]) readRawLocked(name string) ([]byte, bool) {
+	_, ok := sort.Find(len(s.index), func(i int) int {
 		return s.comparator.Compare(name, s.index[i])
 	})
 	if !ok {
-		s.index = append(s.index[:i], append([]string{name}, s.index[i:]...)...)
+		return nil, false
 	}
 
-	return nil
+	f, err := s.fs.Open(filepath.Join(s.dir, name))
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, false
+	}
+
+	return data, true
 }
 
-func (s *LocalStore[T]) Delete(key string) error {
-	name := url.PathEscape(key) + s.ext
-	path := filepath.Join(s.dir, name)
+// putRaw writes already-encoded data under name (an escaped key plus
+// extension) without going through the Encoder and without calling the
+// hook, as used by a Replicator to apply a value pulled from a peer; the
+// replicator tracks the resulting version itself. It decodes data to
+// maintain secondary indexes, so values pulled from a peer stay visible
+// to FindBy and RangeBy.
+func (s *LocalStore[T]) putRaw(name string, data []byte) error {
+	value, err := s.decoder.Decode(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
 
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
+	if err := s.putLocked(name, data); err != nil {
+		return err
+	}
+
+	return s.putIndexes(name, value)
+}
+
+// deleteRaw removes name (an escaped key plus extension) from the store
+// without calling the hook, as used by a Replicator to apply a tombstone
+// pulled from a peer; the replicator tracks the resulting version
+// itself. It also drops name from every secondary index it was filed
+// under.
+func (s *LocalStore[T]) deleteRaw(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
 	i, ok := sort.Find(len(s.index), func(i int) int {
 		return s.comparator.Compare(name, s.index[i])
 	})
@@ -196,14 +433,24 @@ func (s *LocalStore[T]) Delete(key string) error {
 		return nil
 	}
 
-	if err := files.Remove(path); err != nil {
+	if err := s.fs.Remove(filepath.Join(s.dir, name)); err != nil {
 		return err
 	}
-	if files.MustIsEmptyDir(path) {
-		_ = files.Remove(path)
-	}
 
 	s.index = append(s.index[:i], s.index[i+1:]...)
+	s.deleteIndexes(name)
 
 	return nil
 }
+
+// names returns a snapshot of the current index (escaped key plus
+// extension for every stored entry), as used by a Replicator to build
+// its index message.
+func (s *LocalStore[T]) names() []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	names := make([]string, len(s.index))
+	copy(names, s.index)
+	return names
+}
@@ -0,0 +1,89 @@
+package localstore
+
+import (
+	"bytes"
+	"testing"
+)
+
+type record struct {
+	Value string
+}
+
+func newStringStore(fs FS) *LocalStore[record] {
+	return New[record]("/store", &Options[record]{FS: fs})
+}
+
+func TestLocalStoreOverMemFS(t *testing.T) {
+	s := newStringStore(NewMemFS())
+
+	if err := s.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if err := s.Put("a", record{Value: "1"}); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	got, err := s.Get("a")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got.Value != "1" {
+		t.Fatalf("Get() = %+v, want Value=1", got)
+	}
+
+	list, err := s.List(0, -1)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(list.Values) != 1 {
+		t.Fatalf("List() returned %d values, want 1", len(list.Values))
+	}
+
+	if err := s.Delete("a"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := s.Get("a"); err != ErrNotExist {
+		t.Fatalf("Get() after Delete() error = %v, want ErrNotExist", err)
+	}
+}
+
+func TestLocalStoreOverCacheOnRead(t *testing.T) {
+	base := NewMemFS()
+	s := newStringStore(base)
+
+	if err := s.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if err := s.Put("a", record{Value: "1"}); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	cache := NewMemFS()
+	cached := newStringStore(NewCacheOnRead(base, cache))
+
+	if err := cached.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	got, err := cached.Get("a")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got.Value != "1" {
+		t.Fatalf("Get() = %+v, want Value=1", got)
+	}
+
+	f, err := cache.Open("/store/a.dat")
+	if err != nil {
+		t.Fatalf("expected value to be cached after Get(), Open() error = %v", err)
+	}
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(f); err != nil {
+		t.Fatalf("ReadFrom() error = %v", err)
+	}
+	_ = f.Close()
+	if buf.Len() == 0 {
+		t.Fatal("expected cached copy to be non-empty")
+	}
+}
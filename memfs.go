@@ -0,0 +1,229 @@
+package localstore
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemFS is an in-memory FS, useful for tests or for layering a writable
+// overlay over a read-only base FS.
+type MemFS struct {
+	mutex sync.RWMutex
+	files map[string][]byte
+	dirs  map[string]bool
+}
+
+// NewMemFS returns an empty MemFS.
+func NewMemFS() *MemFS {
+	return &MemFS{
+		files: map[string][]byte{},
+		dirs:  map[string]bool{".": true},
+	}
+}
+
+func (m *MemFS) Open(name string) (File, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	name = filepath.Clean(name)
+	if data, ok := m.files[name]; ok {
+		return &memFile{name: name, reader: bytes.NewReader(data), size: int64(len(data))}, nil
+	}
+	if m.dirs[name] {
+		return &memFile{name: name, isDir: true}, nil
+	}
+	return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+}
+
+func (m *MemFS) Create(name string) (File, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	name = filepath.Clean(name)
+	if !m.dirs[filepath.Dir(name)] {
+		return nil, &os.PathError{Op: "create", Path: name, Err: os.ErrNotExist}
+	}
+	return &memWriter{fs: m, name: name}, nil
+}
+
+func (m *MemFS) Remove(name string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	name = filepath.Clean(name)
+	if _, ok := m.files[name]; ok {
+		delete(m.files, name)
+		return nil
+	}
+	if m.dirs[name] {
+		delete(m.dirs, name)
+		return nil
+	}
+	return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+}
+
+func (m *MemFS) Rename(oldname string, newname string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	oldname = filepath.Clean(oldname)
+	newname = filepath.Clean(newname)
+
+	if data, ok := m.files[oldname]; ok {
+		if !m.dirs[filepath.Dir(newname)] {
+			return &os.PathError{Op: "rename", Path: newname, Err: os.ErrNotExist}
+		}
+		m.files[newname] = data
+		delete(m.files, oldname)
+		return nil
+	}
+	if m.dirs[oldname] {
+		m.dirs[newname] = true
+		delete(m.dirs, oldname)
+		return nil
+	}
+	return &os.PathError{Op: "rename", Path: oldname, Err: os.ErrNotExist}
+}
+
+func (m *MemFS) ReadDir(name string) ([]string, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	name = filepath.Clean(name)
+	if !m.dirs[name] {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+
+	names := make([]string, 0)
+	for file := range m.files {
+		if filepath.Dir(file) == name {
+			names = append(names, filepath.Base(file))
+		}
+	}
+	for dir := range m.dirs {
+		if dir != name && filepath.Dir(dir) == name {
+			names = append(names, filepath.Base(dir))
+		}
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+func (m *MemFS) Stat(name string) (fs.FileInfo, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	name = filepath.Clean(name)
+	if data, ok := m.files[name]; ok {
+		return memFileInfo{name: filepath.Base(name), size: int64(len(data))}, nil
+	}
+	if m.dirs[name] {
+		return memFileInfo{name: filepath.Base(name), isDir: true}, nil
+	}
+	return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+}
+
+func (m *MemFS) MkdirAll(name string, perm fs.FileMode) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	name = filepath.Clean(name)
+	for _, dir := range parents(name) {
+		m.dirs[dir] = true
+	}
+
+	return nil
+}
+
+// parents returns name and each of its ancestor directories, from name up
+// to but not including the root, followed by ".". The root "/" itself is
+// never included.
+func parents(name string) []string {
+	dirs := make([]string, 0)
+	for name != "." && name != string(filepath.Separator) {
+		dirs = append(dirs, name)
+		name = filepath.Dir(name)
+	}
+	dirs = append(dirs, ".")
+	return dirs
+}
+
+type memFile struct {
+	name   string
+	reader *bytes.Reader
+	isDir  bool
+	size   int64
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	if f.reader == nil {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: os.ErrInvalid}
+	}
+	return f.reader.Read(p)
+}
+
+func (f *memFile) Write([]byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.name, Err: os.ErrInvalid}
+}
+
+func (f *memFile) Close() error {
+	return nil
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) {
+	return memFileInfo{name: filepath.Base(f.name), size: f.size, isDir: f.isDir}, nil
+}
+
+func (f *memFile) Sync() error {
+	return nil
+}
+
+type memWriter struct {
+	fs     *MemFS
+	name   string
+	buffer bytes.Buffer
+}
+
+func (w *memWriter) Read([]byte) (int, error) {
+	return 0, &os.PathError{Op: "read", Path: w.name, Err: os.ErrInvalid}
+}
+
+func (w *memWriter) Write(p []byte) (int, error) {
+	return w.buffer.Write(p)
+}
+
+func (w *memWriter) Close() error {
+	w.fs.mutex.Lock()
+	defer w.fs.mutex.Unlock()
+
+	w.fs.files[w.name] = append([]byte(nil), w.buffer.Bytes()...)
+
+	return nil
+}
+
+func (w *memWriter) Stat() (fs.FileInfo, error) {
+	return memFileInfo{name: filepath.Base(w.name), size: int64(w.buffer.Len())}, nil
+}
+
+func (w *memWriter) Sync() error {
+	return nil
+}
+
+type memFileInfo struct {
+	name  string
+	size  int64
+	isDir bool
+}
+
+func (fi memFileInfo) Name() string       { return fi.name }
+func (fi memFileInfo) Size() int64        { return fi.size }
+func (fi memFileInfo) Mode() fs.FileMode  { return 0644 }
+func (fi memFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi memFileInfo) IsDir() bool        { return fi.isDir }
+func (fi memFileInfo) Sys() interface{}   { return nil }
@@ -7,6 +7,40 @@ type Options[T any] struct {
 	Comparator Comparator
 	Encoder    Encoder[T]
 	Decoder    Decoder[T]
+
+	// FS is the filesystem LocalStore reads and writes through. It
+	// defaults to the local OS filesystem when nil.
+	FS FS
+
+	// Conflict resolves two values a Replicator finds at the same
+	// version for the same key after independent local writes on two
+	// peers. It must be commutative — Conflict(a, b) and Conflict(b, a)
+	// need to agree on the same result — or two peers syncing
+	// bidirectionally can each adopt the other's value and swap forever
+	// instead of converging. It defaults to deterministically picking
+	// whichever value's encoded bytes compare greater, which converges
+	// but is otherwise arbitrary; supply one of your own (e.g. comparing
+	// a timestamp field on T) for a meaningful resolution.
+	Conflict func(local T, remote T) T
+
+	// ListConcurrency is the number of worker goroutines List uses to
+	// open and decode files. The default, 0 or 1, keeps List sequential
+	// in the caller's goroutine.
+	ListConcurrency int
+
+	// ListThrottle, in [0,1], makes each List worker sleep proportionally
+	// between files, to go easier on a slow or shared filesystem. 0
+	// disables throttling.
+	ListThrottle float64
+
+	// IncludeKey, when set, is consulted before opening each file during
+	// List so callers can cheaply skip keys without paying decode cost.
+	IncludeKey func(key string) bool
+
+	// Indexes maps a secondary index name to the function extracting
+	// its keys from a value, so entries can be looked up with FindBy and
+	// RangeBy instead of scanning the whole store with List.
+	Indexes map[string]func(T) []string
 }
 
 type Encoder[T any] interface {
@@ -0,0 +1,495 @@
+package localstore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Version is a per-key, monotonically increasing counter. It plays the
+// role of a Lamport clock: it is bumped on every local write, and a
+// replica adopts a peer's version whenever it is ahead of its own, so two
+// stores converge without a shared wall clock.
+type Version uint64
+
+// IndexEntry describes one key as known to a replica. Two replicas of
+// the same key converge once their IndexEntry is identical.
+type IndexEntry struct {
+	Key       string
+	Version   Version
+	Hash      [32]byte
+	Size      int64
+	Tombstone bool
+}
+
+// IndexUpdate is delivered on a Replicator's Updates channel whenever a
+// Put or Delete on the underlying LocalStore changes an entry.
+type IndexUpdate struct {
+	IndexEntry
+}
+
+// TombstoneTTL is how long a Replicator remembers a deletion as a
+// tombstone before forgetting it. A peer that has been offline for
+// longer than this and still advertises the deleted key may resurrect
+// it on the next sync.
+var TombstoneTTL = 7 * 24 * time.Hour
+
+// replicaStateDir is where a Replicator persists one small file per key
+// holding its version and, if deleted, the tombstone's expiry, sibling
+// to the store's own .tx and .idx directories, so a process restart
+// picks up where it left off instead of re-reporting Version 0 for
+// everything and forgetting every tombstone. Keeping one file per key,
+// rather than a single encoded map, means a Put or Delete only ever
+// rewrites the one key it touched, not every key the replica knows
+// about.
+const replicaStateDir = ".replica"
+
+// replicaEntry is the on-disk form of one key's replicator state, gob-
+// encoded under replicaStateDir. Tombstone is the zero time.Time for a
+// live key.
+type replicaEntry struct {
+	Version   Version
+	Tombstone time.Time
+}
+
+// NewReplicator returns a Replicator for s. Unless s already has a hook
+// from an earlier Replicator, it registers one on s so that subsequent
+// Put and Delete calls are reflected on Updates. It also
+// loads any versions and tombstones a previous Replicator for s left on
+// disk.
+func NewReplicator[T any](s *LocalStore[T]) *Replicator[T] {
+	r := &Replicator[T]{
+		store:      s,
+		versions:   map[string]Version{},
+		tombstones: map[string]time.Time{},
+		updates:    make(chan IndexUpdate, 256),
+	}
+	if s.conflict != nil {
+		r.conflict = s.conflict
+	} else {
+		r.conflict = func(local T, remote T) T { return s.greaterEncoded(local, remote) }
+	}
+	r.loadState()
+	if s.hook == nil {
+		s.hook = r.onChange
+	}
+	return r
+}
+
+// greaterEncoded deterministically picks between two values found at the
+// same Lamport version, favoring whichever encodes to the greater byte
+// string. Conflict(a, b) and Conflict(b, a) then agree on the same
+// winner regardless of which side calls it "local" versus "remote", so a
+// bidirectional Sync converges instead of both peers adopting the
+// other's value and swapping forever. It falls back to remote if either
+// value fails to encode.
+func (s *LocalStore[T]) greaterEncoded(local T, remote T) T {
+	var localBuf, remoteBuf bytes.Buffer
+	if err := s.encoder.Encode(&localBuf, local); err != nil {
+		return remote
+	}
+	if err := s.encoder.Encode(&remoteBuf, remote); err != nil {
+		return remote
+	}
+	if bytes.Compare(localBuf.Bytes(), remoteBuf.Bytes()) >= 0 {
+		return local
+	}
+	return remote
+}
+
+// stateDir returns the directory holding this replicator's persisted
+// per-key state.
+func (r *Replicator[T]) stateDir() string {
+	return filepath.Join(r.store.dir, replicaStateDir)
+}
+
+// stateEntryPath returns the path of name's persisted version/tombstone
+// file.
+func (r *Replicator[T]) stateEntryPath(name string) string {
+	return filepath.Join(r.stateDir(), name)
+}
+
+// loadState reads a previous run's versions and tombstones from disk, if
+// any, dropping any tombstone that has already expired.
+func (r *Replicator[T]) loadState() {
+	names, err := r.store.fs.ReadDir(r.stateDir())
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	for _, name := range names {
+		f, err := r.store.fs.Open(r.stateEntryPath(name))
+		if err != nil {
+			continue
+		}
+		var entry replicaEntry
+		decErr := gob.NewDecoder(f).Decode(&entry)
+		_ = f.Close()
+		if decErr != nil {
+			continue
+		}
+
+		r.versions[name] = entry.Version
+		if !entry.Tombstone.IsZero() && entry.Tombstone.After(now) {
+			r.tombstones[name] = entry.Tombstone
+		}
+	}
+}
+
+// saveEntryLocked persists name's current version and tombstone (if any)
+// so a later restart doesn't forget them. Unlike re-encoding the whole
+// replica state, this only ever touches the one file for name, so a Put
+// or Delete pays for one small write, not one proportional to every key
+// the replica knows about. r.mutex must be held.
+func (r *Replicator[T]) saveEntryLocked(name string) error {
+	entry := replicaEntry{Version: r.versions[name]}
+	if expires, ok := r.tombstones[name]; ok {
+		entry.Tombstone = expires
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(entry); err != nil {
+		return err
+	}
+
+	if err := r.store.fs.MkdirAll(r.stateDir(), 0755); err != nil {
+		return err
+	}
+	f, err := r.store.fs.Create(r.stateEntryPath(name))
+	if err != nil {
+		return err
+	}
+	_, werr := f.Write(buf.Bytes())
+	if cerr := f.Close(); werr == nil {
+		werr = cerr
+	}
+	return werr
+}
+
+// Replicator exchanges an index of (key, version, hash, size) tuples
+// with a peer's Replicator over an io.ReadWriter, Syncthing-style, and
+// pulls whatever is missing or out of date.
+type Replicator[T any] struct {
+	store    *LocalStore[T]
+	conflict func(local T, remote T) T
+
+	mutex      sync.Mutex
+	versions   map[string]Version
+	tombstones map[string]time.Time
+	updates    chan IndexUpdate
+}
+
+// Updates streams an IndexUpdate for every Put or Delete made on the
+// underlying store, so a peer already synced once can be kept current by
+// forwarding these instead of calling Sync again. The channel is
+// buffered; if it fills because nothing drains it, further updates are
+// dropped and the peer falls back to discovering them on the next Sync.
+func (r *Replicator[T]) Updates() <-chan IndexUpdate {
+	return r.updates
+}
+
+// Index returns the current index of every live entry and still-valid
+// tombstone known to this replica.
+//
+// It reads every entry through r.store.readRaw before ever taking
+// r.mutex: a commit runs applyTx under s.mutex and calls the hook
+// (onChange) while still holding it, which then takes r.mutex, so the
+// store lock must never be taken while r.mutex is held or a concurrent
+// Put/Delete and Sync can deadlock on the reversed lock order.
+func (r *Replicator[T]) Index() []IndexEntry {
+	names := r.store.names()
+
+	type entry struct {
+		name string
+		data []byte
+		ok   bool
+	}
+	entries := make([]entry, len(names))
+	for i, name := range names {
+		data, ok := r.store.readRaw(name)
+		entries[i] = entry{name: name, data: data, ok: ok}
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+	index := make([]IndexEntry, 0, len(entries)+len(r.tombstones))
+	for _, e := range entries {
+		if !e.ok {
+			continue
+		}
+		index = append(index, IndexEntry{
+			Key:     keyFromName(e.name, r.store.ext),
+			Version: r.versions[e.name],
+			Hash:    sha256.Sum256(e.data),
+			Size:    int64(len(e.data)),
+		})
+	}
+	for name, expires := range r.tombstones {
+		if expires.Before(now) {
+			continue
+		}
+		index = append(index, IndexEntry{
+			Key:       keyFromName(name, r.store.ext),
+			Version:   r.versions[name],
+			Tombstone: true,
+		})
+	}
+
+	return index
+}
+
+// onChange is registered as the store's hook and keeps versions,
+// tombstones, and the Updates stream current as Put/Delete run.
+func (r *Replicator[T]) onChange(name string, data []byte, deleted bool) {
+	r.mutex.Lock()
+	r.versions[name]++
+	entry := IndexEntry{
+		Key:       keyFromName(name, r.store.ext),
+		Version:   r.versions[name],
+		Tombstone: deleted,
+	}
+	if deleted {
+		r.tombstones[name] = time.Now().Add(TombstoneTTL)
+	} else {
+		delete(r.tombstones, name)
+		entry.Hash = sha256.Sum256(data)
+		entry.Size = int64(len(data))
+	}
+	_ = r.saveEntryLocked(name)
+	r.mutex.Unlock()
+
+	select {
+	case r.updates <- IndexUpdate{IndexEntry: entry}:
+	default:
+	}
+}
+
+// wireMessage is the single message type exchanged over Sync's
+// io.ReadWriter, tagged by whichever of Request or Response is non-nil;
+// a message with neither is an index, possibly empty.
+type wireMessage struct {
+	Index    []IndexEntry
+	Request  *wireRequest
+	Response *wireResponse
+}
+
+type wireRequest struct {
+	Key string
+}
+
+type wireResponse struct {
+	Key   string
+	Found bool
+	Data  []byte
+}
+
+// Sync exchanges the full index with the peer on the other end of rw,
+// pulls every entry the peer has that this replica is missing or behind
+// on, and serves the peer's own pulls against this replica. It returns
+// once this replica's pulls are complete; a goroutine keeps serving the
+// peer's requests until rw is closed.
+func (r *Replicator[T]) Sync(rw io.ReadWriter) error {
+	enc := gob.NewEncoder(rw)
+	dec := gob.NewDecoder(rw)
+
+	var writeMutex sync.Mutex
+	send := func(msg wireMessage) error {
+		writeMutex.Lock()
+		defer writeMutex.Unlock()
+		return enc.Encode(msg)
+	}
+
+	var pendingMutex sync.Mutex
+	pending := map[string]chan wireResponse{}
+
+	indexCh := make(chan []IndexEntry, 1)
+	errCh := make(chan error, 1)
+
+	go func() {
+		for {
+			var msg wireMessage
+			if err := dec.Decode(&msg); err != nil {
+				select {
+				case errCh <- err:
+				default:
+				}
+				return
+			}
+
+			switch {
+			case msg.Request == nil && msg.Response == nil:
+				// gob drops an empty slice, so a peer with nothing to
+				// advertise sends an index that arrives as nil.
+				indexCh <- msg.Index
+
+			case msg.Request != nil:
+				// Answer off this goroutine so it keeps reading while the
+				// reply waits on writeMutex; otherwise both peers can block
+				// writing to a reader that is itself blocked writing.
+				key := msg.Request.Key
+				go func() {
+					data, found := r.store.readRaw(nameFromKey(key, r.store.ext))
+					_ = send(wireMessage{Response: &wireResponse{Key: key, Found: found, Data: data}})
+				}()
+
+			case msg.Response != nil:
+				pendingMutex.Lock()
+				ch := pending[msg.Response.Key]
+				delete(pending, msg.Response.Key)
+				pendingMutex.Unlock()
+				if ch != nil {
+					ch <- *msg.Response
+				}
+			}
+		}
+	}()
+
+	if err := send(wireMessage{Index: r.Index()}); err != nil {
+		return err
+	}
+
+	var remote []IndexEntry
+	select {
+	case remote = <-indexCh:
+	case err := <-errCh:
+		return err
+	}
+
+	for _, entry := range r.wanted(remote) {
+		if entry.Tombstone {
+			// A tombstone has no data on the peer to pull.
+			if err := r.apply(entry, nil); err != nil {
+				return err
+			}
+			continue
+		}
+
+		respCh := make(chan wireResponse, 1)
+		pendingMutex.Lock()
+		pending[entry.Key] = respCh
+		pendingMutex.Unlock()
+
+		if err := send(wireMessage{Request: &wireRequest{Key: entry.Key}}); err != nil {
+			return err
+		}
+
+		var resp wireResponse
+		select {
+		case resp = <-respCh:
+		case err := <-errCh:
+			return err
+		}
+		if !resp.Found {
+			continue
+		}
+		if err := r.apply(entry, resp.Data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// wanted returns the entries from remote that this replica should pull:
+// anything it does not have, has at an older version, or has at the same
+// version with different live content.
+func (r *Replicator[T]) wanted(remote []IndexEntry) []IndexEntry {
+	local := r.Index()
+	byKey := make(map[string]IndexEntry, len(local))
+	for _, entry := range local {
+		byKey[entry.Key] = entry
+	}
+
+	wanted := make([]IndexEntry, 0)
+	for _, entry := range remote {
+		current, ok := byKey[entry.Key]
+		switch {
+		case !ok:
+			wanted = append(wanted, entry)
+		case current.Version < entry.Version:
+			wanted = append(wanted, entry)
+		case current.Version == entry.Version && current.Hash != entry.Hash && !entry.Tombstone && !current.Tombstone:
+			// Both replicas advanced this key to the same Lamport
+			// version independently: a genuine conflict to resolve
+			// rather than a stale copy to overwrite.
+			wanted = append(wanted, entry)
+		}
+	}
+	return wanted
+}
+
+// apply applies a pulled entry to the store, running the Conflict hook
+// when both replicas have advanced a key to the same version with
+// different content.
+func (r *Replicator[T]) apply(entry IndexEntry, data []byte) error {
+	name := nameFromKey(entry.Key, r.store.ext)
+
+	r.mutex.Lock()
+	local := r.versions[name]
+	r.mutex.Unlock()
+
+	if entry.Tombstone {
+		r.mutex.Lock()
+		r.versions[name] = entry.Version
+		r.tombstones[name] = time.Now().Add(TombstoneTTL)
+		saveErr := r.saveEntryLocked(name)
+		r.mutex.Unlock()
+		if saveErr != nil {
+			return saveErr
+		}
+		return r.store.deleteRaw(name)
+	}
+
+	if local == entry.Version {
+		if current, ok := r.store.readRaw(name); ok && sha256.Sum256(current) != entry.Hash {
+			localValue, err := r.store.decoder.Decode(bytes.NewReader(current))
+			if err != nil {
+				return err
+			}
+			remoteValue, err := r.store.decoder.Decode(bytes.NewReader(data))
+			if err != nil {
+				return err
+			}
+
+			var buf bytes.Buffer
+			if err := r.store.encoder.Encode(&buf, r.conflict(localValue, remoteValue)); err != nil {
+				return err
+			}
+			data = buf.Bytes()
+		}
+	}
+
+	r.mutex.Lock()
+	if entry.Version > r.versions[name] {
+		r.versions[name] = entry.Version
+	}
+	delete(r.tombstones, name)
+	saveErr := r.saveEntryLocked(name)
+	r.mutex.Unlock()
+	if saveErr != nil {
+		return saveErr
+	}
+
+	return r.store.putRaw(name, data)
+}
+
+func keyFromName(name string, ext string) string {
+	key, err := url.PathUnescape(strings.TrimSuffix(name, ext))
+	if err != nil {
+		return strings.TrimSuffix(name, ext)
+	}
+	return key
+}
+
+func nameFromKey(key string, ext string) string {
+	return url.PathEscape(key) + ext
+}
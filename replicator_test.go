@@ -0,0 +1,211 @@
+package localstore
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReplicatorSync(t *testing.T) {
+	a := newStringStore(NewMemFS())
+	if err := a.Load(); err != nil {
+		t.Fatalf("a.Load() error = %v", err)
+	}
+	if err := a.Put("x", record{Value: "from-a"}); err != nil {
+		t.Fatalf("a.Put() error = %v", err)
+	}
+	ra := NewReplicator(a)
+
+	b := newStringStore(NewMemFS())
+	if err := b.Load(); err != nil {
+		t.Fatalf("b.Load() error = %v", err)
+	}
+	if err := b.Put("y", record{Value: "from-b"}); err != nil {
+		t.Fatalf("b.Put() error = %v", err)
+	}
+	rb := NewReplicator(b)
+
+	connA, connB := net.Pipe()
+	defer connA.Close()
+	defer connB.Close()
+
+	errCh := make(chan error, 2)
+	go func() { errCh <- ra.Sync(connA) }()
+	go func() { errCh <- rb.Sync(connB) }()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errCh:
+			if err != nil {
+				t.Fatalf("Sync() error = %v", err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("Sync() did not complete in time")
+		}
+	}
+
+	got, err := a.Get("y")
+	if err != nil {
+		t.Fatalf("a.Get(y) error = %v", err)
+	}
+	if got.Value != "from-b" {
+		t.Fatalf("a.Get(y) = %+v, want Value=from-b", got)
+	}
+
+	got, err = b.Get("x")
+	if err != nil {
+		t.Fatalf("b.Get(x) error = %v", err)
+	}
+	if got.Value != "from-a" {
+		t.Fatalf("b.Get(x) = %+v, want Value=from-a", got)
+	}
+}
+
+func TestReplicatorPersistsStateAcrossRestart(t *testing.T) {
+	fs := NewMemFS()
+	s := newStringStore(fs)
+	if err := s.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if err := s.Put("a", record{Value: "1"}); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	r := NewReplicator(s)
+	if err := s.Delete("a"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	name := nameFromKey("a", s.ext)
+	r.mutex.Lock()
+	wantVersion := r.versions[name]
+	r.mutex.Unlock()
+	if wantVersion == 0 {
+		t.Fatalf("expected a non-zero version for %q after delete", name)
+	}
+
+	// Simulate the process restarting: a fresh Replicator built over the
+	// same store/FS should pick up the persisted version and tombstone
+	// instead of re-reporting Version 0 and forgetting the deletion,
+	// which would let a peer resurrect the key on the next Sync.
+	restarted := NewReplicator(s)
+	restarted.mutex.Lock()
+	gotVersion := restarted.versions[name]
+	_, tombstoned := restarted.tombstones[name]
+	restarted.mutex.Unlock()
+
+	if gotVersion != wantVersion {
+		t.Fatalf("versions[%q] after restart = %d, want %d", name, gotVersion, wantVersion)
+	}
+	if !tombstoned {
+		t.Fatalf("expected %q to still be tombstoned after restart", name)
+	}
+}
+
+func TestIndexDoesNotDeadlockWithConcurrentWrites(t *testing.T) {
+	s := newStringStore(NewMemFS())
+	if err := s.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	r := NewReplicator(s)
+
+	// Commit takes s.mutex and applyTx then calls the hook (onChange),
+	// which takes r.mutex: order s -> r. If Index ever took r.mutex before reading
+	// through the store (which takes s.mutex), a Put racing a Sync/Index
+	// could deadlock on the reversed order r -> s. Hammer both from many
+	// goroutines against a small, fixed set of keys so the store doesn't
+	// grow unbounded — Index's own cost scales with store size, and an
+	// ever-growing store would make this test slow for reasons that have
+	// nothing to do with the lock order being tested.
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				_ = r.Index()
+			}
+		}()
+	}
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				if err := s.Put(fmt.Sprintf("key-%d", i%10), record{Value: "v"}); err != nil {
+					t.Errorf("Put() error = %v", err)
+					return
+				}
+			}
+		}(w)
+	}
+
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Index() and concurrent Put() deadlocked")
+	}
+}
+
+func TestDefaultConflictIsCommutative(t *testing.T) {
+	s := newStringStore(NewMemFS())
+	if err := s.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	r := NewReplicator(s)
+
+	a := record{Value: "aaa"}
+	b := record{Value: "bbb"}
+
+	// Conflict(a, b) and Conflict(b, a) must agree on the same winner, or
+	// two peers resolving the same conflict from opposite sides would
+	// each adopt the other's value and swap forever instead of
+	// converging.
+	winner1 := r.conflict(a, b)
+	winner2 := r.conflict(b, a)
+	if winner1 != winner2 {
+		t.Fatalf("conflict(a, b) = %+v, conflict(b, a) = %+v, want the same winner", winner1, winner2)
+	}
+}
+
+func TestReplicatorUpdatesOnPutAndDelete(t *testing.T) {
+	a := newStringStore(NewMemFS())
+	if err := a.Load(); err != nil {
+		t.Fatalf("a.Load() error = %v", err)
+	}
+	ra := NewReplicator(a)
+
+	if err := a.Put("z", record{Value: "1"}); err != nil {
+		t.Fatalf("a.Put() error = %v", err)
+	}
+
+	select {
+	case update := <-ra.Updates():
+		if update.Key != "z" || update.Tombstone {
+			t.Fatalf("unexpected update = %+v", update)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected an update after Put()")
+	}
+
+	if err := a.Delete("z"); err != nil {
+		t.Fatalf("a.Delete() error = %v", err)
+	}
+
+	select {
+	case update := <-ra.Updates():
+		if update.Key != "z" || !update.Tombstone {
+			t.Fatalf("unexpected update = %+v", update)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a tombstone update after Delete()")
+	}
+}
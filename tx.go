@@ -0,0 +1,391 @@
+package localstore
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"path/filepath"
+	"sort"
+)
+
+// ErrConflict is returned by Tx.Commit when a transaction built inside
+// Update finds that one of the keys it touched was changed by another
+// commit after Update started it.
+var ErrConflict = errors.New("localstore: conflict")
+
+// updateMaxRetries bounds how many times Update retries a transaction
+// that keeps losing to concurrent commits.
+const updateMaxRetries = 10
+
+// txManifest lists the part files a Tx staged and the keys it intends to
+// remove. Writing it, fsynced, after every staged file is itself fsynced
+// is what lets Load tell a finished-but-not-yet-applied commit from a
+// half-written one.
+type txManifest struct {
+	Puts    []string
+	Removes []string
+}
+
+type txOp[T any] struct {
+	name    string
+	deleted bool
+	data    []byte
+	value   T
+
+	hasBaseline bool
+	baseline    []byte
+}
+
+// Tx buffers Put and Delete calls and applies them together on Commit,
+// staging new values under <dir>/.tx/<id>/ and fsyncing them and a
+// manifest before touching the primary store, so a crash mid-commit
+// leaves either the old or the fully-new state, never a half-written
+// file.
+type Tx[T any] struct {
+	store         *LocalStore[T]
+	id            string
+	conflictCheck bool
+	ops           []txOp[T]
+}
+
+// Begin returns a new transaction against s. Nothing is written until
+// Commit is called.
+func (s *LocalStore[T]) Begin() *Tx[T] {
+	return s.begin(false)
+}
+
+func (s *LocalStore[T]) begin(conflictCheck bool) *Tx[T] {
+	return &Tx[T]{store: s, id: newTxID(), conflictCheck: conflictCheck}
+}
+
+// Update runs fn against a fresh Tx and commits it, retrying with a new
+// Tx if Commit reports ErrConflict because another commit changed a key
+// fn touched. fn should read current values through s, not a stale copy
+// captured before the retry.
+func (s *LocalStore[T]) Update(fn func(tx *Tx[T]) error) error {
+	var err error
+	for attempt := 0; attempt <= updateMaxRetries; attempt++ {
+		tx := s.begin(true)
+		if err = fn(tx); err != nil {
+			return err
+		}
+		err = tx.Commit()
+		if err == nil || err != ErrConflict {
+			return err
+		}
+	}
+	return err
+}
+
+// Put buffers value to be written under key when the transaction
+// commits.
+func (tx *Tx[T]) Put(key string, value T) error {
+	s := tx.store
+	name := nameFromKey(key, s.ext)
+
+	var buf bytes.Buffer
+	if err := s.encoder.Encode(&buf, value); err != nil {
+		return err
+	}
+
+	op := txOp[T]{name: name, data: buf.Bytes(), value: value}
+	if tx.conflictCheck {
+		op.baseline, op.hasBaseline = s.readRaw(name)
+	}
+	tx.ops = append(tx.ops, op)
+
+	return nil
+}
+
+// Delete buffers the removal of key to happen when the transaction
+// commits.
+func (tx *Tx[T]) Delete(key string) error {
+	s := tx.store
+	name := nameFromKey(key, s.ext)
+
+	op := txOp[T]{name: name, deleted: true}
+	if tx.conflictCheck {
+		op.baseline, op.hasBaseline = s.readRaw(name)
+	}
+	tx.ops = append(tx.ops, op)
+
+	return nil
+}
+
+// Commit stages every buffered Put under <dir>/.tx/<id>/ and fsyncs the
+// staged files, then — holding s.mutex from here on — runs the conflict check
+// before writing the manifest, so a manifest is only ever fsynced for a
+// transaction that already won its conflict check; a crash can then
+// only recover a commit that was going to be applied anyway. Once the
+// manifest is fsynced it renames the staged files into place, removes
+// deleted keys, and updates in-memory indexes. If the transaction was
+// started by Update and another commit changed a touched key in the
+// meantime, Commit returns ErrConflict without applying anything or
+// ever writing a manifest.
+func (tx *Tx[T]) Commit() error {
+	if len(tx.ops) == 0 {
+		return nil
+	}
+
+	// Only the last op buffered for a key takes effect, keeping the
+	// earliest baseline, so each key is staged or removed once and
+	// recoverTx's Puts-then-Removes replay matches applyTx.
+	pos := make(map[string]int, len(tx.ops))
+	ops := make([]txOp[T], 0, len(tx.ops))
+	for _, op := range tx.ops {
+		if i, ok := pos[op.name]; ok {
+			op.baseline, op.hasBaseline = ops[i].baseline, ops[i].hasBaseline
+			ops[i] = op
+			continue
+		}
+		pos[op.name] = len(ops)
+		ops = append(ops, op)
+	}
+	tx.ops = ops
+
+	s := tx.store
+	stageDir := s.txStageDir(tx.id)
+
+	if err := s.fs.MkdirAll(stageDir, 0755); err != nil {
+		return err
+	}
+
+	manifest := txManifest{}
+	for _, op := range tx.ops {
+		if op.deleted {
+			manifest.Removes = append(manifest.Removes, op.name)
+			continue
+		}
+
+		f, err := s.fs.Create(filepath.Join(stageDir, op.name))
+		if err != nil {
+			_ = s.removeTxStage(tx.id)
+			return err
+		}
+		_, werr := f.Write(op.data)
+		if werr == nil {
+			werr = f.Sync()
+		}
+		if cerr := f.Close(); werr == nil {
+			werr = cerr
+		}
+		if werr != nil {
+			_ = s.removeTxStage(tx.id)
+			return werr
+		}
+
+		manifest.Puts = append(manifest.Puts, op.name)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if tx.conflictCheck {
+		for _, op := range tx.ops {
+			current, ok := s.readRawLocked(op.name)
+			if ok != op.hasBaseline || (ok && !bytes.Equal(current, op.baseline)) {
+				_ = s.removeTxStage(tx.id)
+				return ErrConflict
+			}
+		}
+	}
+
+	if err := s.writeTxManifest(tx.id, manifest); err != nil {
+		_ = s.removeTxStage(tx.id)
+		return err
+	}
+
+	if err := s.applyTx(tx, stageDir); err != nil {
+		return err
+	}
+
+	return s.removeTxStage(tx.id)
+}
+
+// applyTx renames staged files into place, removes deleted keys, and
+// updates the in-memory index, secondary indexes, and replicator hook
+// for every op. s.mutex must be held.
+func (s *LocalStore[T]) applyTx(tx *Tx[T], stageDir string) error {
+	for _, op := range tx.ops {
+		if op.deleted {
+			_ = s.fs.Remove(filepath.Join(s.dir, op.name))
+			s.removeFromIndexLocked(op.name)
+			s.deleteIndexes(op.name)
+			if s.hook != nil {
+				s.hook(op.name, nil, true)
+			}
+			continue
+		}
+
+		if err := s.fs.MkdirAll(s.dir, 0755); err != nil {
+			return err
+		}
+		if err := s.fs.Rename(filepath.Join(stageDir, op.name), filepath.Join(s.dir, op.name)); err != nil {
+			return err
+		}
+		s.insertIntoIndexLocked(op.name)
+
+		if err := s.putIndexes(op.name, op.value); err != nil {
+			return err
+		}
+		if s.hook != nil {
+			s.hook(op.name, op.data, false)
+		}
+	}
+
+	return nil
+}
+
+// insertIntoIndexLocked adds name to the sorted in-memory index if it
+// isn't already present. s.mutex must be held.
+func (s *LocalStore[T]) insertIntoIndexLocked(name string) {
+	i, ok := sort.Find(len(s.index), func(i int) int {
+		return s.comparator.Compare(name, s.index[i])
+	})
+	if !ok {
+		s.index = append(s.index[:i], append([]string{name}, s.index[i:]...)...)
+	}
+}
+
+// removeFromIndexLocked drops name from the sorted in-memory index if
+// present. s.mutex must be held.
+func (s *LocalStore[T]) removeFromIndexLocked(name string) {
+	i, ok := sort.Find(len(s.index), func(i int) int {
+		return s.comparator.Compare(name, s.index[i])
+	})
+	if ok {
+		s.index = append(s.index[:i], s.index[i+1:]...)
+	}
+}
+
+func (s *LocalStore[T]) txDir() string {
+	return filepath.Join(s.dir, ".tx")
+}
+
+func (s *LocalStore[T]) txStageDir(id string) string {
+	return filepath.Join(s.txDir(), id)
+}
+
+func (s *LocalStore[T]) txManifestPath(id string) string {
+	return filepath.Join(s.txStageDir(id), "manifest")
+}
+
+func (s *LocalStore[T]) writeTxManifest(id string, manifest txManifest) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(manifest); err != nil {
+		return err
+	}
+
+	f, err := s.fs.Create(s.txManifestPath(id))
+	if err != nil {
+		return err
+	}
+	_, werr := f.Write(buf.Bytes())
+	if werr == nil {
+		werr = f.Sync()
+	}
+	if cerr := f.Close(); werr == nil {
+		werr = cerr
+	}
+	return werr
+}
+
+// recoverTx finishes or discards a leftover <dir>/.tx/<id>/ left behind
+// by a crash. A manifest found on disk can only have gotten there after
+// every part file it names was itself fsynced, so recovery always means
+// finishing the commit, never rolling it back; a stage directory with no
+// manifest never got that far and is simply discarded.
+//
+// It also replays the manifest against any configured secondary indexes,
+// since the crash happened before the original applyTx could do so: a
+// rename alone would otherwise leave FindBy/RangeBy stale for the
+// recovered keys until the next full rebuildIndex.
+func (s *LocalStore[T]) recoverTx(id string) error {
+	stageDir := s.txStageDir(id)
+
+	f, err := s.fs.Open(s.txManifestPath(id))
+	if err != nil {
+		return s.removeTxStage(id)
+	}
+
+	var manifest txManifest
+	decErr := gob.NewDecoder(f).Decode(&manifest)
+	_ = f.Close()
+	if decErr != nil {
+		return s.removeTxStage(id)
+	}
+
+	if err := s.fs.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+
+	for _, name := range manifest.Puts {
+		staged := filepath.Join(stageDir, name)
+		if _, err := s.fs.Stat(staged); err != nil {
+			continue
+		}
+		if err := s.fs.Rename(staged, filepath.Join(s.dir, name)); err != nil {
+			return err
+		}
+	}
+	for _, name := range manifest.Removes {
+		_ = s.fs.Remove(filepath.Join(s.dir, name))
+		s.deleteIndexes(name)
+	}
+
+	for _, name := range manifest.Puts {
+		value, ok, err := s.readRecovered(name)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if err := s.putIndexes(name, value); err != nil {
+			return err
+		}
+	}
+
+	return s.removeTxStage(id)
+}
+
+// readRecovered decodes the value stored under name directly off s.fs,
+// bypassing s.index, which recoverTx runs before Load has rebuilt it.
+func (s *LocalStore[T]) readRecovered(name string) (T, bool, error) {
+	f, err := s.fs.Open(filepath.Join(s.dir, name))
+	if err != nil {
+		return *new(T), false, nil
+	}
+	defer f.Close()
+
+	value, err := s.decoder.Decode(f)
+	if err != nil {
+		return *new(T), false, err
+	}
+
+	return value, true, nil
+}
+
+// removeTxStage deletes a transaction's staging directory and whatever
+// is left in it.
+func (s *LocalStore[T]) removeTxStage(id string) error {
+	stageDir := s.txStageDir(id)
+
+	names, err := s.fs.ReadDir(stageDir)
+	if err != nil {
+		return nil
+	}
+	for _, name := range names {
+		_ = s.fs.Remove(filepath.Join(stageDir, name))
+	}
+
+	return s.fs.Remove(stageDir)
+}
+
+func newTxID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
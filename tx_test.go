@@ -0,0 +1,221 @@
+package localstore
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestTxCommitAppliesAllOps(t *testing.T) {
+	s := newStringStore(NewMemFS())
+	if err := s.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if err := s.Put("a", record{Value: "1"}); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	tx := s.Begin()
+	if err := tx.Put("a", record{Value: "2"}); err != nil {
+		t.Fatalf("Tx.Put() error = %v", err)
+	}
+	if err := tx.Put("b", record{Value: "1"}); err != nil {
+		t.Fatalf("Tx.Put() error = %v", err)
+	}
+	if err := tx.Delete("a"); err != nil {
+		t.Fatalf("Tx.Delete() error = %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Tx.Commit() error = %v", err)
+	}
+
+	if _, err := s.Get("a"); err != ErrNotExist {
+		t.Fatalf("Get(a) after commit error = %v, want ErrNotExist", err)
+	}
+	got, err := s.Get("b")
+	if err != nil {
+		t.Fatalf("Get(b) error = %v", err)
+	}
+	if got.Value != "1" {
+		t.Fatalf("Get(b) = %+v, want Value=1", got)
+	}
+}
+
+func TestLoadRecoversCrashedCommit(t *testing.T) {
+	fs := NewMemFS()
+	s := newStringStore(fs)
+	if err := s.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	// Stage a commit as Tx.Commit would, but stop short of renaming the
+	// part file into place or cleaning up the stage dir, as a crash right
+	// after the manifest fsync would leave things.
+	tx := s.Begin()
+	if err := tx.Put("a", record{Value: "1"}); err != nil {
+		t.Fatalf("Tx.Put() error = %v", err)
+	}
+	stageDir := s.txStageDir(tx.id)
+	if err := fs.MkdirAll(stageDir, 0755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	op := tx.ops[0]
+	f, err := fs.Create(filepath.Join(stageDir, op.name))
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if _, err := f.Write(op.data); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := s.writeTxManifest(tx.id, txManifest{Puts: []string{op.name}}); err != nil {
+		t.Fatalf("writeTxManifest() error = %v", err)
+	}
+
+	// A fresh Load over the same FS should finish the commit rather than
+	// lose it.
+	recovered := newStringStore(fs)
+	if err := recovered.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	got, err := recovered.Get("a")
+	if err != nil {
+		t.Fatalf("Get() after recovery error = %v", err)
+	}
+	if got.Value != "1" {
+		t.Fatalf("Get() after recovery = %+v, want Value=1", got)
+	}
+	if _, err := fs.Stat(stageDir); err == nil {
+		t.Fatal("expected stage dir to be cleaned up after recovery")
+	}
+}
+
+func TestLoadRecoversIndexesAfterCrashedCommit(t *testing.T) {
+	fs := NewMemFS()
+	s := newPeopleStore(fs)
+	if err := s.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	// Stage a commit as Tx.Commit would, but stop short of renaming the
+	// part file into place, as a crash right after the manifest fsync
+	// would leave things.
+	tx := s.Begin()
+	if err := tx.Put("alice", person{Name: "alice", City: "nyc"}); err != nil {
+		t.Fatalf("Tx.Put() error = %v", err)
+	}
+	stageDir := s.txStageDir(tx.id)
+	if err := fs.MkdirAll(stageDir, 0755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	op := tx.ops[0]
+	f, err := fs.Create(filepath.Join(stageDir, op.name))
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if _, err := f.Write(op.data); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := s.writeTxManifest(tx.id, txManifest{Puts: []string{op.name}}); err != nil {
+		t.Fatalf("writeTxManifest() error = %v", err)
+	}
+
+	// A fresh Load over the same FS should finish the commit and also
+	// bring the secondary index for the recovered key up to date, rather
+	// than leaving it stale until the next full rebuild.
+	recovered := newPeopleStore(fs)
+	if err := recovered.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	got, err := recovered.FindBy("city", "nyc")
+	if err != nil {
+		t.Fatalf("FindBy() error = %v", err)
+	}
+	if len(got) != 1 || got[0].Name != "alice" {
+		t.Fatalf("FindBy(city, nyc) after recovery = %+v, want alice", got)
+	}
+}
+
+func TestCommitConflictNeverWritesManifest(t *testing.T) {
+	fs := NewMemFS()
+	s := newStringStore(fs)
+	if err := s.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if err := s.Put("a", record{Value: "0"}); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	tx := s.begin(true)
+	if err := tx.Put("a", record{Value: "1"}); err != nil {
+		t.Fatalf("Tx.Put() error = %v", err)
+	}
+
+	// Another writer lands after tx captured its baseline, so tx is
+	// bound to lose its conflict check on Commit.
+	if err := s.Put("a", record{Value: "stolen"}); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	if err := tx.Commit(); err != ErrConflict {
+		t.Fatalf("Commit() error = %v, want ErrConflict", err)
+	}
+
+	// A manifest must never exist on disk for a commit that lost its
+	// conflict check, or a crash right after this point would let
+	// recoverTx finish applying it anyway.
+	if _, err := fs.Stat(s.txManifestPath(tx.id)); err == nil {
+		t.Fatal("expected no manifest to have been written for a losing conflict check")
+	}
+	if _, err := fs.Stat(s.txStageDir(tx.id)); err == nil {
+		t.Fatal("expected stage dir to be cleaned up after a losing conflict check")
+	}
+}
+
+func TestUpdateRetriesOnConflict(t *testing.T) {
+	s := newStringStore(NewMemFS())
+	if err := s.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if err := s.Put("a", record{Value: "0"}); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	first := true
+	err := s.Update(func(tx *Tx[record]) error {
+		got, err := s.Get("a")
+		if err != nil {
+			return err
+		}
+		if err := tx.Put("a", record{Value: got.Value + "!"}); err != nil {
+			return err
+		}
+		if first {
+			// Simulate another writer landing after this Tx captured its
+			// baseline but before it commits.
+			first = false
+			if err := s.Put("a", record{Value: "stolen"}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+
+	got, err := s.Get("a")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got.Value != "stolen!" {
+		t.Fatalf("Get() = %+v, want Value=stolen!", got)
+	}
+}